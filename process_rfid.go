@@ -4,13 +4,17 @@ import (
 	"compress/gzip"
 	"encoding/csv"
 	"encoding/gob"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"os"
 	"path"
+	"runtime"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/kshedden/rfid2/rfid"
@@ -23,7 +27,7 @@ var (
 	logger *log.Logger
 )
 
-// Sort first by CSN, then by time, used for patients
+// Sort first by CSN, then by time, used for patients
 type byCSNTime []*rfid.RFIDrecord
 
 func (a byCSNTime) Len() int      { return len(a) }
@@ -59,7 +63,7 @@ func (a byUMidTime) Less(i, j int) bool {
 
 // readDay reads all records for a single day, and returns two RFIDrecord arrays,
 // containing RFIDrecord structs for patients and for providers respectively.
-func readDay(year, month, day int) ([]*rfid.RFIDrecord, []*rfid.RFIDrecord) {
+func readDay(cfg *rfid.Config, year, month, day int) ([]*rfid.RFIDrecord, []*rfid.RFIDrecord) {
 
 	// Each day of data is in a different file
 	fname := fmt.Sprintf("%4d-%02d-%02d_APD.csv.gz", year, month, day)
@@ -102,16 +106,22 @@ func readDay(year, month, day int) ([]*rfid.RFIDrecord, []*rfid.RFIDrecord) {
 		n++
 
 		r := new(rfid.RFIDrecord)
-		if !r.Parse(fields, &rfi) {
+		if !r.Parse(fields, &rfi, cfg) {
 			continue
 		}
 
-		// Exclude records when clinic is closed
-		if r.TimeStamp.Hour() < 7 {
+		// Exclude records when the clinic is closed
+		h, open := cfg.DayHours(r.TimeStamp.Weekday())
+		if !open {
+			rfi.TimeLate++
+			continue
+		}
+		hour := r.TimeStamp.Hour()
+		if hour < h.Open {
 			rfi.TimeEarly++
 			continue
 		}
-		if r.TimeStamp.Hour() > 19 {
+		if hour >= h.Close {
 			rfi.TimeLate++
 			continue
 		}
@@ -151,8 +161,6 @@ type xr struct {
 	signal float32
 }
 
-var xvr []xr
-
 type xrs []xr
 
 func (a xrs) Len() int      { return len(a) }
@@ -161,45 +169,56 @@ func (a xrs) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 // Less is backward so we can sort in reversed order
 func (a xrs) Less(i, j int) bool { return a[i].signal > a[j].signal }
 
-func processMinute(recs []*rfid.RFIDrecord, signals []float32) time.Time {
+// worker holds the scratch state used while ingesting one day's data.
+// Each concurrent ingestion goroutine gets its own worker, so that the
+// xvr scratch slice (formerly a package-level variable) is never
+// shared across goroutines.
+type worker struct {
+	cfg *rfid.Config
+	xvr []xr
+}
+
+func (w *worker) processMinute(recs []*rfid.RFIDrecord, signals []float32) time.Time {
 
 	t0 := recs[0].TimeStamp.Truncate(time.Minute)
 
-	if cap(xvr) < len(recs) {
-		xvr = make([]xr, len(recs))
+	if cap(w.xvr) < len(recs) {
+		w.xvr = make([]xr, len(recs))
 	}
-	xvr = xvr[0:len(recs)]
+	w.xvr = w.xvr[0:len(recs)]
 
 	// Keep at most 120 pings per minute (average 2/second)
 	for j, r := range recs {
-		xvr[j].room = r.IP
-		xvr[j].signal = float32(math.Exp(float64(r.Signal) / 10))
+		w.xvr[j].room = r.IP
+		w.xvr[j].signal = float32(math.Exp(float64(r.Signal) / 10))
 	}
-	sort.Sort(xrs(xvr))
-	if len(xvr) > 120 {
-		xvr = xvr[0:120]
+	sort.Sort(xrs(w.xvr))
+	if len(w.xvr) > 120 {
+		w.xvr = w.xvr[0:120]
 	}
 
-	for j := range signals {
+	for j := 0; j < w.cfg.NumRooms(); j++ {
 		signals[j] = 0
 	}
 
-	for _, v := range xvr {
+	for _, v := range w.xvr {
 		signals[v.room] += v.signal
 	}
 
 	return t0
 }
 
-func saverec(r *rfid.RFIDrecord, tm time.Time, signals []float32, clarityRec *rfid.ClarityRecord,
-	enc *gob.Encoder) {
+// makeSignalRec builds a SignalRec for one minute of a person's data.
+// signals is copied so that the caller's reused scratch slice can be
+// overwritten for the next minute without corrupting this record.
+func makeSignalRec(r *rfid.RFIDrecord, tm time.Time, signals []float32, clarityRec *rfid.ClarityRecord) rfid.SignalRec {
 
 	ox := rfid.SignalRec{
 		TagId:     r.TagId,
 		UMid:      r.UMid,
 		CSN:       r.CSN,
 		TimeStamp: tm,
-		Signals:   signals,
+		Signals:   append([]float32(nil), signals...),
 	}
 
 	if clarityRec != nil {
@@ -207,12 +226,10 @@ func saverec(r *rfid.RFIDrecord, tm time.Time, signals []float32, clarityRec *rf
 		ox.ClarityEnd = clarityRec.CheckOutTime
 	}
 
-	if err := enc.Encode(&ox); err != nil {
-		panic(err)
-	}
+	return ox
 }
 
-func processPerson(recs []*rfid.RFIDrecord, signals []float32, enc *gob.Encoder) {
+func (w *worker) processPerson(recs []*rfid.RFIDrecord, signals []float32) []rfid.SignalRec {
 
 	// Check if the CSN is in the Clarity data
 	var clarityRec *rfid.ClarityRecord
@@ -230,6 +247,7 @@ func processPerson(recs []*rfid.RFIDrecord, signals []float32, enc *gob.Encoder)
 		}
 	}
 
+	var out []rfid.SignalRec
 	for len(recs) > 0 {
 		i, f := 0, false
 		for i = range recs {
@@ -241,10 +259,178 @@ func processPerson(recs []*rfid.RFIDrecord, signals []float32, enc *gob.Encoder)
 		if !f {
 			i += 1
 		}
-		tm := processMinute(recs[0:i], signals)
-		saverec(recs[0], tm, signals, clarityRec, enc)
+		tm := w.processMinute(recs[0:i], signals)
+		out = append(out, makeSignalRec(recs[0], tm, signals, clarityRec))
+		recs = recs[i:len(recs)]
+	}
+	return out
+}
+
+// splitByID splits recs into consecutive runs that share the same id,
+// as returned by id.  recs must already be sorted by id.
+func splitByID(recs []*rfid.RFIDrecord, id func(*rfid.RFIDrecord) uint64) [][]*rfid.RFIDrecord {
+
+	var groups [][]*rfid.RFIDrecord
+	for len(recs) > 0 {
+		want := id(recs[0])
+		i, f := 0, false
+		for i = range recs {
+			if id(recs[i]) != want {
+				f = true
+				break
+			}
+		}
+		if !f {
+			i += 1
+		}
+		groups = append(groups, recs[0:i])
 		recs = recs[i:len(recs)]
 	}
+	return groups
+}
+
+func csn(r *rfid.RFIDrecord) uint64  { return r.CSN }
+func umid(r *rfid.RFIDrecord) uint64 { return r.UMid }
+
+// personBatch holds the decoded per-minute signal records for one
+// person on one day.
+type personBatch struct {
+	recs []rfid.SignalRec
+}
+
+// dayResult holds everything produced by ingesting a single day,
+// tagged with dayIndex so that results can be written out in
+// deterministic, chronological order even though days are ingested
+// concurrently and may finish out of order.
+type dayResult struct {
+	dayIndex  int
+	date      time.Time
+	patients  []personBatch
+	providers []personBatch
+	nPatient  int
+	nProvider int
+}
+
+// processDay ingests one day's data and groups it into per-person
+// batches of decoded signal records, using w's scratch state.
+func processDay(w *worker, dayIndex int, date time.Time) dayResult {
+
+	patrecs, provrecs := readDay(w.cfg, date.Year(), int(date.Month()), date.Day())
+
+	signals := make([]float32, w.cfg.NumRooms())
+
+	res := dayResult{
+		dayIndex:  dayIndex,
+		date:      date,
+		nPatient:  len(patrecs),
+		nProvider: len(provrecs),
+	}
+
+	for _, g := range splitByID(patrecs, csn) {
+		res.patients = append(res.patients, personBatch{recs: w.processPerson(g, signals)})
+	}
+	for _, g := range splitByID(provrecs, umid) {
+		res.providers = append(res.providers, personBatch{recs: w.processPerson(g, signals)})
+	}
+
+	return res
+}
+
+// batchMsg carries one day's per-person batches for a single output
+// file (patient or provider), tagged with dayIndex so the serializer
+// can reassemble chronological order.
+type batchMsg struct {
+	dayIndex int
+	batches  []personBatch
+}
+
+// serialize receives batchMsg values in arbitrary order and writes
+// them to fw in order of increasing dayIndex, buffering any message
+// that arrives ahead of its turn.  This is the single goroutine
+// responsible for writing to fw, so the underlying SignalWriter does
+// not need to be safe for concurrent use.
+func serialize(fw rfid.SignalWriter, in <-chan batchMsg, done chan<- struct{}) {
+
+	pending := make(map[int][]personBatch)
+	next := 0
+	for msg := range in {
+		pending[msg.dayIndex] = msg.batches
+		for {
+			batches, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			for _, b := range batches {
+				for j := range b.recs {
+					if err := fw.Write(&b.recs[j]); err != nil {
+						panic(err)
+					}
+				}
+			}
+			next++
+		}
+	}
+	close(done)
+}
+
+// multiCloser closes a sequence of io.Closers in order, wrapping them
+// as a single io.Closer.  It is used to close a gzip.Writer before the
+// *os.File underneath it, mirroring the nested defer order that a
+// single-format writer would use.
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var firstErr error
+	for _, c := range m {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// newSignalWriter opens the output file for group ("patient" or
+// "provider") in the given format ("gob", "csv", or "parquet") and
+// returns a SignalWriter for it along with the io.Closer(s) needed to
+// close the underlying file.
+func newSignalWriter(format, group string, cfg *rfid.Config) (rfid.SignalWriter, io.Closer, error) {
+
+	switch format {
+	case "gob":
+		f, err := os.Create(group + "_signals.gob.gz")
+		if err != nil {
+			return nil, nil, err
+		}
+		g := gzip.NewWriter(f)
+		return rfid.NewFrameWriter(g), multiCloser{g, f}, nil
+
+	case "csv":
+		f, err := os.Create(group + "_signals.csv.gz")
+		if err != nil {
+			return nil, nil, err
+		}
+		g := gzip.NewWriter(f)
+		w, err := rfid.NewCSVWriter(g, cfg, group)
+		if err != nil {
+			return nil, nil, err
+		}
+		return w, multiCloser{g, f}, nil
+
+	case "parquet":
+		f, err := os.Create(group + "_signals.parquet")
+		if err != nil {
+			return nil, nil, err
+		}
+		w, err := rfid.NewParquetWriter(f, cfg, group)
+		if err != nil {
+			return nil, nil, err
+		}
+		return w, f, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown -format %q, expected gob, csv, or parquet", format)
+	}
 }
 
 func readClarity() {
@@ -265,81 +451,114 @@ func readClarity() {
 	dec.Decode(&clarity)
 }
 
+// parseDayRange parses a "YYYY-MM-DD..YYYY-MM-DD" date range flag
+// value into its start and end dates, inclusive.
+func parseDayRange(s string) (time.Time, time.Time, error) {
+
+	parts := strings.SplitN(s, "..", 2)
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid -days range %q, expected YYYY-MM-DD..YYYY-MM-DD", s)
+	}
+
+	start, err := time.Parse("2006-01-02", parts[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid -days start date %q: %v", parts[0], err)
+	}
+	end, err := time.Parse("2006-01-02", parts[1])
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid -days end date %q: %v", parts[1], err)
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("-days end date %q is before start date %q", parts[1], parts[0])
+	}
+
+	return start, end, nil
+}
+
 func main() {
 
+	workers := flag.Int("workers", runtime.NumCPU(), "number of concurrent day-ingestion workers")
+	days := flag.String("days", "2018-01-01..2018-12-31", "date range to process, as YYYY-MM-DD..YYYY-MM-DD")
+	format := flag.String("format", "gob", "output format for the per-day signal files: gob, csv, or parquet")
+	flag.Parse()
+
 	setupLog()
 	readClarity()
 
-	// Setup encoders for patients and providers
-	var enc [2]*gob.Encoder
+	cfg, err := rfid.LoadConfig("clinic.json")
+	if err != nil {
+		panic(err)
+	}
+
+	start, end, err := parseDayRange(*days)
+	if err != nil {
+		panic(err)
+	}
+
+	var dates []time.Time
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d)
+	}
+
+	// Setup signal writers for patients and providers, in the
+	// requested output format.
+	groups := [2]string{"patient", "provider"}
+	var fw [2]rfid.SignalWriter
+	var closers [2]io.Closer
 	for j := 0; j < 2; j++ {
-		fname := "patient_signals.gob.gz"
-		if j == 1 {
-			fname = "provider_signals.gob.gz"
-		}
-		f, err := os.Create(fname)
+		w, c, err := newSignalWriter(*format, groups[j], cfg)
 		if err != nil {
 			panic(err)
 		}
-		defer f.Close()
-		g := gzip.NewWriter(f)
-		defer g.Close()
-
-		enc[j] = gob.NewEncoder(g)
+		fw[j] = w
+		closers[j] = c
 	}
 
-	signals := make([]float32, len(rfid.IPcode))
-
-	for year := 2018; year <= 2018; year++ {
-		for month := 1; month <= 12; month++ {
-			for day := 1; day <= 31; day++ {
+	dayIndexCh := make(chan int)
+	patientCh := make(chan batchMsg)
+	providerCh := make(chan batchMsg)
+
+	patientDone := make(chan struct{})
+	providerDone := make(chan struct{})
+	go serialize(fw[0], patientCh, patientDone)
+	go serialize(fw[1], providerCh, providerDone)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := &worker{cfg: cfg}
+			for idx := range dayIndexCh {
+				res := processDay(w, idx, dates[idx])
+
+				logger.Printf("%s: %d patient recs, %d provider recs", res.date.Format("2006-01-02"), res.nPatient, res.nProvider)
+				fmt.Printf("%s %d %d\n", res.date.Format("2006-01-02"), res.nProvider, res.nPatient)
+
+				patientCh <- batchMsg{dayIndex: idx, batches: res.patients}
+				providerCh <- batchMsg{dayIndex: idx, batches: res.providers}
+			}
+		}()
+	}
 
-				patrecs, provrecs := readDay(year, month, day)
-				fmt.Printf("%d-%d-%d %d %d\n", year, month, day, len(provrecs), len(patrecs))
+	for i := range dates {
+		dayIndexCh <- i
+	}
+	close(dayIndexCh)
 
-				for j := 0; j < 2; j++ {
+	wg.Wait()
+	close(patientCh)
+	close(providerCh)
 
-					var v []*rfid.RFIDrecord
-					if j == 0 {
-						v = patrecs
-					} else {
-						v = provrecs
-					}
+	<-patientDone
+	<-providerDone
 
-					for len(v) > 0 {
-						var id uint64
-						switch j {
-						case 0:
-							id = v[0].CSN
-						case 1:
-							id = v[0].UMid
-						default:
-							panic("")
-						}
-						i, f := 0, false
-						for i = range v {
-							var id1 uint64
-							switch j {
-							case 0:
-								id1 = v[i].CSN
-							case 1:
-								id1 = v[i].UMid
-							default:
-								panic("")
-							}
-							if id1 != id {
-								f = true
-								break
-							}
-						}
-						if !f {
-							i += 1
-						}
-						processPerson(v[0:i], signals, enc[j])
-						v = v[i:len(v)]
-					}
-				}
-			}
+	for j := 0; j < 2; j++ {
+		if err := fw[j].Close(); err != nil {
+			panic(err)
+		}
+		if err := closers[j].Close(); err != nil {
+			panic(err)
 		}
 	}
 }
@@ -0,0 +1,152 @@
+package rfid
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RoomCategory labels the functional category of a room, used for
+// grouping rooms in downstream reports.
+type RoomCategory string
+
+// Room category labels recognized in a config file.
+const (
+	CategoryExam  RoomCategory = "Exam"
+	CategoryField RoomCategory = "Field"
+	CategoryAdmin RoomCategory = "Admin"
+)
+
+// RoomConfig describes one room tracked by the RFID system.
+type RoomConfig struct {
+	Name     string       `json:"name" yaml:"name"`
+	IP       string       `json:"ip" yaml:"ip"`
+	Category RoomCategory `json:"category" yaml:"category"`
+}
+
+// DayHours gives the clinic's open and close hour (0-23, half-open
+// interval [Open, Close)) for one weekday.
+type DayHours struct {
+	Weekday time.Weekday `json:"weekday" yaml:"weekday"`
+	Open    int          `json:"open" yaml:"open"`
+	Close   int          `json:"close" yaml:"close"`
+}
+
+// Config holds the site-specific configuration for one clinic
+// deployment: the room/IP mapping, the clinic's open hours by
+// weekday, and the text labels for person and provider types.  Use
+// LoadConfig to read a Config from a JSON or YAML file, rather than
+// constructing one directly.
+type Config struct {
+	Rooms         []RoomConfig            `json:"rooms" yaml:"rooms"`
+	Hours         []DayHours              `json:"hours" yaml:"hours"`
+	PersonTypes   map[PersonType]string   `json:"personTypes" yaml:"personTypes"`
+	ProviderTypes map[ProviderType]string `json:"providerTypes" yaml:"providerTypes"`
+
+	ipCode   map[string]RoomCode
+	roomName map[RoomCode]string
+	noSignal RoomCode
+	hours    map[time.Weekday]DayHours
+}
+
+// LoadConfig reads a clinic configuration from path.  The file format
+// is chosen from the file extension: ".json" for JSON, ".yaml" or
+// ".yml" for YAML.
+func LoadConfig(path string) (*Config, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("rfid: reading config: %w", err)
+	}
+
+	cfg := new(Config)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(data, cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	default:
+		return nil, fmt.Errorf("rfid: unrecognized config file extension %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rfid: parsing config %s: %w", path, err)
+	}
+
+	cfg.ipCode = make(map[string]RoomCode)
+	cfg.roomName = make(map[RoomCode]string)
+	for j, r := range cfg.Rooms {
+		code := RoomCode(j)
+		cfg.ipCode[r.IP] = code
+		cfg.roomName[code] = r.Name
+	}
+
+	// NoSignal is always the code one past the last configured room.
+	cfg.noSignal = RoomCode(len(cfg.Rooms))
+	cfg.roomName[cfg.noSignal] = "NoSignal"
+
+	cfg.hours = make(map[time.Weekday]DayHours)
+	for _, h := range cfg.Hours {
+		cfg.hours[h.Weekday] = h
+	}
+
+	return cfg, nil
+}
+
+// RoomCode returns the room code associated with ip, and whether ip is
+// a recognized room address.
+func (cfg *Config) RoomCode(ip string) (RoomCode, bool) {
+	c, ok := cfg.ipCode[ip]
+	return c, ok
+}
+
+// RoomName returns the display name of the room identified by code,
+// including the name "NoSignal" for the code returned by NoSignal.
+func (cfg *Config) RoomName(code RoomCode) string {
+	return cfg.roomName[code]
+}
+
+// NoSignal returns the RoomCode used to represent the absence of any
+// room signal for a given minute.
+func (cfg *Config) NoSignal() RoomCode {
+	return cfg.noSignal
+}
+
+// NumRooms returns the number of distinct room codes, including the
+// NoSignal code, so that callers can size a per-minute signal vector.
+func (cfg *Config) NumRooms() int {
+	return len(cfg.Rooms) + 1
+}
+
+// DayHours returns the configured open/close hours for weekday, and
+// whether any hours are configured for that weekday.
+func (cfg *Config) DayHours(weekday time.Weekday) (DayHours, bool) {
+	h, ok := cfg.hours[weekday]
+	return h, ok
+}
+
+// IsOpen reports whether the clinic is open at time t, based on the
+// configured hours for t's weekday.  A weekday with no configured
+// hours is treated as closed.
+func (cfg *Config) IsOpen(t time.Time) bool {
+	h, ok := cfg.DayHours(t.Weekday())
+	if !ok {
+		return false
+	}
+	hour := t.Hour()
+	return hour >= h.Open && hour < h.Close
+}
+
+// PersonTypeName returns the display label for a person type code.
+func (cfg *Config) PersonTypeName(pt PersonType) string {
+	return cfg.PersonTypes[pt]
+}
+
+// ProviderTypeName returns the display label for a provider type code.
+func (cfg *Config) ProviderTypeName(pt ProviderType) string {
+	return cfg.ProviderTypes[pt]
+}
@@ -0,0 +1,79 @@
+package rfid
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// CSVWriter writes a stream of SignalRec values directly to CSV,
+// without an intermediate gob-encoded artifact.  group selects the
+// row shape: "patient" rows include CSN and the Clarity check-in/
+// check-out columns, "provider" rows include UMid.
+type CSVWriter struct {
+	enc   *csv.Writer
+	group string
+	row   []string
+}
+
+// NewCSVWriter returns a CSVWriter that writes to w, writing the
+// header row immediately.
+func NewCSVWriter(w io.Writer, cfg *Config, group string) (*CSVWriter, error) {
+
+	var hdr []string
+	switch group {
+	case "patient":
+		hdr = []string{"TagId", "CSN", "ClarityStart", "ClarityEnd", "Time"}
+	case "provider":
+		hdr = []string{"TagId", "UMid", "Time"}
+	default:
+		return nil, fmt.Errorf("rfid: unknown group %q", group)
+	}
+	for k := 0; k < cfg.NumRooms(); k++ {
+		hdr = append(hdr, cfg.RoomName(RoomCode(k)))
+	}
+
+	cw := &CSVWriter{enc: csv.NewWriter(w), group: group}
+	if err := cw.enc.Write(hdr); err != nil {
+		return nil, err
+	}
+
+	return cw, nil
+}
+
+// Write appends rec as one CSV row.
+func (cw *CSVWriter) Write(rec *SignalRec) error {
+
+	cw.row = cw.row[0:0]
+	cw.row = append(cw.row, fmt.Sprintf("%d", rec.TagId))
+
+	switch cw.group {
+	case "provider":
+		cw.row = append(cw.row, fmt.Sprintf("%d", rec.UMid))
+	case "patient":
+		cw.row = append(cw.row, fmt.Sprintf("%d", rec.CSN))
+		if !rec.ClarityStart.IsZero() {
+			cw.row = append(cw.row, rec.ClarityStart.Format("2006-01-02T15:04"))
+		} else {
+			cw.row = append(cw.row, "")
+		}
+		if !rec.ClarityEnd.IsZero() {
+			cw.row = append(cw.row, rec.ClarityEnd.Format("2006-01-02T15:04"))
+		} else {
+			cw.row = append(cw.row, "")
+		}
+	}
+
+	cw.row = append(cw.row, rec.TimeStamp.Format("2006-01-02T15:04"))
+	for _, z := range rec.Signals {
+		cw.row = append(cw.row, fmt.Sprintf("%.0f", 1000000*z))
+	}
+
+	return cw.enc.Write(cw.row)
+}
+
+// Close flushes any buffered CSV output.
+func (cw *CSVWriter) Close() error {
+	cw.enc.Flush()
+	return cw.enc.Error()
+}
@@ -0,0 +1,204 @@
+package rfid
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"hash/crc32"
+	"io"
+)
+
+// frameHeaderSize is the size in bytes of a frame header: an 8-byte
+// little-endian payload length, a 4-byte IEEE CRC32 of that length
+// field, and a 4-byte IEEE CRC32 of the payload.
+//
+// NOTE: this is a 16-byte header, not the 12-byte (8-byte length +
+// 4-byte payload CRC) layout originally specified for this format.
+// The length field itself must be CRC-checked before it is trusted
+// to size a read (see FrameReader.readHeader) or a single corrupted
+// length byte can drive an arbitrarily large allocation and desync
+// every frame after it. Any out-of-process reader written against
+// the original 12-byte spec needs to be updated for this 16-byte
+// on-disk format.
+const frameHeaderSize = 16
+
+// maxFrameLen bounds a frame's payload length.  It guards against a
+// corrupted length field driving an arbitrarily large allocation; no
+// legitimate gob-encoded SignalRec comes anywhere close to this size.
+const maxFrameLen = 1 << 30 // 1 GiB
+
+// FrameWriter writes a sequence of SignalRec values to an underlying
+// io.Writer using a CRC-framed record format.  Each frame consists of
+// an 8-byte little-endian payload length, a 4-byte IEEE CRC32 checksum
+// of that length field, a 4-byte IEEE CRC32 checksum of the payload,
+// and the gob-encoded SignalRec bytes.  Wrapping the underlying writer
+// in a gzip.Writer, as the existing gob output does, compresses the
+// framed stream as a whole.
+//
+// Framing lets a long processing run be interrupted and restarted: a
+// FrameReader can detect and skip a corrupted or partially-written
+// frame instead of failing the entire decode.
+type FrameWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewFrameWriter returns a FrameWriter that writes frames to w.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w}
+}
+
+// Close is a no-op; FrameWriter does not own w, so callers are
+// responsible for closing it themselves.  It exists so that
+// FrameWriter satisfies the SignalWriter interface alongside the CSV
+// and Parquet writers, which do need to flush or finalize state.
+func (fw *FrameWriter) Close() error {
+	return nil
+}
+
+// Write appends rec to the stream as a single CRC-checked frame.
+func (fw *FrameWriter) Write(rec *SignalRec) error {
+
+	fw.buf.Reset()
+	enc := gob.NewEncoder(&fw.buf)
+	if err := enc.Encode(rec); err != nil {
+		return err
+	}
+	payload := fw.buf.Bytes()
+
+	var hdr [frameHeaderSize]byte
+	binary.LittleEndian.PutUint64(hdr[0:8], uint64(len(payload)))
+	binary.LittleEndian.PutUint32(hdr[8:12], crc32.ChecksumIEEE(hdr[0:8]))
+	binary.LittleEndian.PutUint32(hdr[12:16], crc32.ChecksumIEEE(payload))
+
+	if _, err := fw.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := fw.w.Write(payload); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FrameStats accumulates counts describing how a FrameReader handled
+// an input stream.
+type FrameStats struct {
+
+	// Frames is the number of frames whose header validated and whose
+	// payload was read in full, including those whose payload then
+	// failed to validate.  A frame skipped because its header was
+	// corrupt is counted only in CorruptFrames.
+	Frames int
+
+	// CorruptFrames is the number of frames whose header or payload
+	// failed to validate (bad length CRC, implausible length, bad
+	// payload CRC, or undecodable gob payload).  These frames are
+	// skipped rather than causing Next to fail.
+	CorruptFrames int
+
+	// Truncated is set if the stream ended in the middle of a frame,
+	// which happens when a processing run is interrupted mid-write.
+	// This is not treated as an error.
+	Truncated bool
+}
+
+// FrameReader reads a sequence of SignalRec values previously written
+// by a FrameWriter.  It verifies the CRC32 checksum of a frame's
+// length field before trusting it, and the CRC32 checksum of the
+// payload, skipping and counting any frame that fails either check
+// instead of returning an error.  A corrupted length field cannot be
+// trusted enough to size a read, so FrameReader resynchronizes by
+// scanning forward for the next position at which a valid header
+// appears, rather than risking an unbounded allocation or a
+// permanently desynchronized stream.  A truncated final frame is
+// treated as a clean end of stream so that decoding can resume from
+// wherever a prior run left off.
+type FrameReader struct {
+	r     *bufio.Reader
+	Stats FrameStats
+}
+
+// NewFrameReader returns a FrameReader that reads frames from r.
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next valid SignalRec in the stream.  It returns
+// io.EOF when the stream is exhausted, including when it ends with a
+// truncated frame (see FrameStats.Truncated).
+func (fr *FrameReader) Next() (*SignalRec, error) {
+
+	for {
+		hdr, ok := fr.readHeader()
+		if !ok {
+			return nil, io.EOF
+		}
+
+		n := binary.LittleEndian.Uint64(hdr[0:8])
+		wantPayloadCRC := binary.LittleEndian.Uint32(hdr[12:16])
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(fr.r, payload); err != nil {
+			fr.Stats.Truncated = true
+			return nil, io.EOF
+		}
+
+		fr.Stats.Frames++
+
+		if crc32.ChecksumIEEE(payload) != wantPayloadCRC {
+			fr.Stats.CorruptFrames++
+			continue
+		}
+
+		var rec SignalRec
+		dec := gob.NewDecoder(bytes.NewReader(payload))
+		if err := dec.Decode(&rec); err != nil {
+			fr.Stats.CorruptFrames++
+			continue
+		}
+
+		return &rec, nil
+	}
+}
+
+// readHeader reads the next frameHeaderSize-byte header whose length
+// CRC validates and whose length is within maxFrameLen.  If the
+// header at the current stream position is corrupt, it is counted as
+// one CorruptFrame and the stream is resynchronized by scanning
+// forward one byte at a time for the next position at which a valid
+// header appears, rather than trusting an unverified length.  It
+// returns false once the stream is exhausted before a valid header is
+// found.
+func (fr *FrameReader) readHeader() (hdr [frameHeaderSize]byte, ok bool) {
+
+	if _, err := io.ReadFull(fr.r, hdr[:]); err != nil {
+		if err != io.EOF {
+			fr.Stats.Truncated = true
+		}
+		return hdr, false
+	}
+
+	if !validHeader(hdr) {
+		fr.Stats.CorruptFrames++
+		for !validHeader(hdr) {
+			b, err := fr.r.ReadByte()
+			if err != nil {
+				return hdr, false
+			}
+			copy(hdr[:], hdr[1:])
+			hdr[frameHeaderSize-1] = b
+		}
+	}
+
+	return hdr, true
+}
+
+// validHeader reports whether hdr's length field CRC validates and
+// the length it carries is within maxFrameLen.
+func validHeader(hdr [frameHeaderSize]byte) bool {
+	n := binary.LittleEndian.Uint64(hdr[0:8])
+	wantHeaderCRC := binary.LittleEndian.Uint32(hdr[8:12])
+	return n <= maxFrameLen && crc32.ChecksumIEEE(hdr[0:8]) == wantHeaderCRC
+}
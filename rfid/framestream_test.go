@@ -0,0 +1,202 @@
+package rfid
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testSignalRecs() []*SignalRec {
+	t0 := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	return []*SignalRec{
+		{
+			TagId:     1,
+			CSN:       100,
+			TimeStamp: t0,
+			Signals:   []float32{0.1, 0.2, 0.3},
+		},
+		{
+			TagId:        1,
+			CSN:          100,
+			TimeStamp:    t0.Add(time.Minute),
+			Signals:      []float32{0.4, 0.5, 0.6},
+			ClarityStart: t0,
+			ClarityEnd:   t0.Add(30 * time.Minute),
+		},
+		{
+			TagId:     2,
+			UMid:      200,
+			TimeStamp: t0.Add(2 * time.Minute),
+			Signals:   []float32{0.7, 0.8, 0.9},
+		},
+	}
+}
+
+func writeFrames(t *testing.T, recs []*SignalRec) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	fw := NewFrameWriter(&buf)
+	for _, rec := range recs {
+		if err := fw.Write(rec); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	want := testSignalRecs()
+	data := writeFrames(t, want)
+
+	fr := NewFrameReader(bytes.NewReader(data))
+	var got []*SignalRec
+	for {
+		rec, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got = append(got, rec)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for j := range want {
+		if !reflect.DeepEqual(got[j], want[j]) {
+			t.Errorf("record %d: got %+v, want %+v", j, got[j], want[j])
+		}
+	}
+	if fr.Stats.CorruptFrames != 0 {
+		t.Errorf("CorruptFrames = %d, want 0", fr.Stats.CorruptFrames)
+	}
+	if fr.Stats.Truncated {
+		t.Errorf("Truncated = true, want false")
+	}
+}
+
+// TestFrameCorruptPayload flips a byte inside the second frame's
+// payload and checks that it is skipped rather than returned or
+// mistaken for a framing error, and that decoding resumes cleanly
+// with the following frame.
+func TestFrameCorruptPayload(t *testing.T) {
+	want := testSignalRecs()
+	data := writeFrames(t, want)
+
+	// Locate the second frame's payload by re-deriving frame
+	// boundaries from the headers, then flip a payload byte.
+	off := 0
+	for i := 0; i < 2; i++ {
+		n := int(leUint64(data[off : off+8]))
+		if i == 1 {
+			data[off+frameHeaderSize] ^= 0xff
+		}
+		off += frameHeaderSize + n
+	}
+
+	fr := NewFrameReader(bytes.NewReader(data))
+	var got []*SignalRec
+	for {
+		rec, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got = append(got, rec)
+	}
+
+	if len(got) != len(want)-1 {
+		t.Fatalf("got %d records, want %d", len(got), len(want)-1)
+	}
+	if fr.Stats.CorruptFrames != 1 {
+		t.Errorf("CorruptFrames = %d, want 1", fr.Stats.CorruptFrames)
+	}
+	if !reflect.DeepEqual(got[0], want[0]) {
+		t.Errorf("record 0: got %+v, want %+v", got[0], want[0])
+	}
+	if !reflect.DeepEqual(got[1], want[2]) {
+		t.Errorf("record 1: got %+v, want %+v", got[1], want[2])
+	}
+}
+
+// TestFrameCorruptLength flips every bit of the first byte of the
+// first frame's length field and checks that FrameReader neither allocates based on the
+// garbled length nor gets permanently stuck, but resyncs onto the
+// next valid frame.
+func TestFrameCorruptLength(t *testing.T) {
+	want := testSignalRecs()
+	data := writeFrames(t, want)
+
+	data[0] ^= 0xff
+
+	fr := NewFrameReader(bytes.NewReader(data))
+	var got []*SignalRec
+	for {
+		rec, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got = append(got, rec)
+	}
+
+	if len(got) != len(want)-1 {
+		t.Fatalf("got %d records, want %d", len(got), len(want)-1)
+	}
+	if fr.Stats.CorruptFrames == 0 {
+		t.Errorf("CorruptFrames = 0, want > 0")
+	}
+	if !reflect.DeepEqual(got[0], want[1]) {
+		t.Errorf("record 0: got %+v, want %+v", got[0], want[1])
+	}
+	if !reflect.DeepEqual(got[1], want[2]) {
+		t.Errorf("record 1: got %+v, want %+v", got[1], want[2])
+	}
+}
+
+// TestFrameTruncated checks that a stream ending mid-frame is
+// reported as a clean, non-panicking end of stream with Truncated
+// set, rather than an error.
+func TestFrameTruncated(t *testing.T) {
+	want := testSignalRecs()
+	data := writeFrames(t, want)
+
+	// Cut the stream off partway through the last frame's payload.
+	data = data[:len(data)-2]
+
+	fr := NewFrameReader(bytes.NewReader(data))
+	var got []*SignalRec
+	for {
+		rec, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got = append(got, rec)
+	}
+
+	if len(got) != len(want)-1 {
+		t.Fatalf("got %d records, want %d", len(got), len(want)-1)
+	}
+	if !fr.Stats.Truncated {
+		t.Errorf("Truncated = false, want true")
+	}
+}
+
+func leUint64(b []byte) uint64 {
+	var n uint64
+	for i := 7; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	return n
+}
@@ -0,0 +1,202 @@
+// Package locate decodes a per-minute sequence of RFID signal vectors
+// into a single most-likely room sequence, using a first-order hidden
+// Markov model over room-occupancy states, decoded with Viterbi.
+package locate
+
+import (
+	"math"
+	"time"
+
+	"github.com/kshedden/rfid2/rfid"
+)
+
+// Params controls the Viterbi room-occupancy decoder.  The state
+// space is the set of room codes in a SignalRec's Signals vector,
+// with the last state treated as the NoSignal state (see
+// rfid.Config.NoSignal).
+type Params struct {
+
+	// PStay is the one-minute transition probability of remaining in
+	// the same room.  It does not apply to the NoSignal state, whose
+	// stay probability is set by PRoomGivenNoSignal.
+	PStay float64
+
+	// PNoSignalGivenRoom is the one-minute transition probability
+	// from a room to the NoSignal state.
+	PNoSignalGivenRoom float64
+
+	// PRoomGivenNoSignal is the total one-minute transition
+	// probability of leaving the NoSignal state for some room, split
+	// evenly across all rooms; the remainder is the probability of
+	// staying in NoSignal.
+	PRoomGivenNoSignal float64
+
+	// Eps smooths the per-minute signal vector before it is treated
+	// as an approximate emission posterior.
+	Eps float64
+}
+
+// DefaultParams returns reasonable default decoder parameters.
+func DefaultParams() Params {
+	return Params{
+		PStay:              0.9,
+		PNoSignalGivenRoom: 0.02,
+		PRoomGivenNoSignal: 0.05,
+		Eps:                1e-6,
+	}
+}
+
+// Decode runs a first-order HMM/Viterbi decoder over a per-minute
+// sequence of signal vectors, one per SignalRec, and returns the
+// single most likely RoomCode for each minute.  recs must be sorted
+// by TimeStamp and share a common number of rooms (len(Signals)).
+func Decode(recs []rfid.SignalRec, params Params) []rfid.RoomCode {
+
+	n := len(recs)
+	if n == 0 {
+		return nil
+	}
+	k := len(recs[0].Signals)
+
+	emit := make([][]float64, n)
+	for t, r := range recs {
+		emit[t] = emissions(r.Signals, params.Eps)
+	}
+
+	// delta[t][j] is the log-probability of the most likely state
+	// sequence ending in state j at minute t; back[t][j] is the state
+	// it came from.
+	delta := make([][]float64, n)
+	back := make([][]int, n)
+	for t := 0; t < n; t++ {
+		delta[t] = make([]float64, k)
+		back[t] = make([]int, k)
+	}
+	copy(delta[0], emit[0])
+
+	for t := 1; t < n; t++ {
+		gap := int(recs[t].TimeStamp.Sub(recs[t-1].TimeStamp) / time.Minute)
+		if gap < 1 {
+			gap = 1
+		}
+		trans := logTransition(k, params, gap)
+
+		for j := 0; j < k; j++ {
+			best, bestI := math.Inf(-1), 0
+			for i := 0; i < k; i++ {
+				if v := delta[t-1][i] + trans[i][j]; v > best {
+					best, bestI = v, i
+				}
+			}
+			delta[t][j] = best + emit[t][j]
+			back[t][j] = bestI
+		}
+	}
+
+	path := make([]rfid.RoomCode, n)
+	best, bestJ := math.Inf(-1), 0
+	for j := 0; j < k; j++ {
+		if delta[n-1][j] > best {
+			best, bestJ = delta[n-1][j], j
+		}
+	}
+	path[n-1] = rfid.RoomCode(bestJ)
+	for t := n - 2; t >= 0; t-- {
+		bestJ = back[t+1][bestJ]
+		path[t] = rfid.RoomCode(bestJ)
+	}
+
+	return path
+}
+
+// emissions returns the log emission likelihood for each state, given
+// a per-minute signal vector, treating the eps-smoothed normalized
+// signal as an approximate posterior.
+func emissions(signals []float32, eps float64) []float64 {
+
+	var sum float64
+	for _, s := range signals {
+		sum += float64(s)
+	}
+	denom := math.Log(sum + float64(len(signals))*eps)
+
+	out := make([]float64, len(signals))
+	for j, s := range signals {
+		out[j] = math.Log(float64(s)+eps) - denom
+	}
+	return out
+}
+
+// logTransition builds the log-space transition matrix to use for a
+// step spanning gap minutes.  The stay probability is decayed by
+// PStay^gap, and the mass that decay frees up (relative to the
+// one-minute baseline) is routed entirely into the NoSignal
+// transition, so that a long gap between consecutive minutes favors
+// the hypothesis that the person went off signal rather than that
+// they instantaneously relocated to a different room.
+func logTransition(k int, params Params, gap int) [][]float64 {
+
+	noSignal := k - 1
+	r := k - 1 // number of real (non-NoSignal) rooms
+
+	pStayBase := params.PStay
+	pStay := math.Pow(params.PStay, float64(gap))
+	freedByGap := pStayBase - pStay
+	if freedByGap < 0 {
+		freedByGap = 0
+	}
+
+	a := make([][]float64, k)
+	for i := range a {
+		a[i] = make([]float64, k)
+	}
+
+	remaining := 1 - pStayBase - params.PNoSignalGivenRoom
+	var each float64
+	if r > 1 && remaining > 0 {
+		each = remaining / float64(r-1)
+	}
+	for i := 0; i < r; i++ {
+		for j := 0; j < r; j++ {
+			if j == i {
+				a[i][j] = pStay
+			} else {
+				a[i][j] = each
+			}
+		}
+		if r > 1 {
+			a[i][noSignal] = params.PNoSignalGivenRoom + freedByGap
+		} else {
+			// With only one real room there are no siblings to
+			// absorb "remaining", so all non-stay mass goes to
+			// NoSignal.
+			a[i][noSignal] = 1 - pStay
+		}
+	}
+
+	if r > 0 {
+		each := params.PRoomGivenNoSignal / float64(r)
+		for j := 0; j < r; j++ {
+			a[noSignal][j] = each
+		}
+	}
+	a[noSignal][noSignal] = 1 - params.PRoomGivenNoSignal
+
+	logA := make([][]float64, k)
+	for i := range a {
+		logA[i] = make([]float64, k)
+		for j := range a[i] {
+			// Clamp away non-positive mass (which can only arise
+			// from a misconfigured Params, e.g. PRoomGivenNoSignal
+			// > 1) so that Log never sees a NaN-producing input.
+			p := math.Max(a[i][j], minTransitionProb)
+			logA[i][j] = math.Log(p)
+		}
+	}
+	return logA
+}
+
+// minTransitionProb is the smallest transition probability that
+// logTransition will produce; it floors entries that would otherwise
+// be zero or negative due to misconfigured Params.
+const minTransitionProb = 1e-9
@@ -0,0 +1,122 @@
+package locate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/kshedden/rfid2/rfid"
+)
+
+// rowSum returns the sum of exp(logA[i][j]) over j, used to check
+// that logTransition's rows are valid probability distributions.
+func rowSum(logA [][]float64, i int) float64 {
+	var sum float64
+	for _, lp := range logA[i] {
+		sum += math.Exp(lp)
+	}
+	return sum
+}
+
+// TestLogTransitionRowsSumToOne checks that every row of the
+// transition matrix is a valid probability distribution, including
+// with DefaultParams and a realistic room count, where a naive
+// PRoomGivenNoSignal*r computation would go negative.
+func TestLogTransitionRowsSumToOne(t *testing.T) {
+	params := DefaultParams()
+	for _, k := range []int{2, 5, 25} { // 25 = 24 rooms + NoSignal
+		for _, gap := range []int{1, 2, 10} {
+			logA := logTransition(k, params, gap)
+			for i := 0; i < k; i++ {
+				if s := rowSum(logA, i); math.Abs(s-1) > 1e-6 {
+					t.Errorf("k=%d gap=%d row %d sums to %v, want 1", k, gap, i, s)
+				}
+			}
+		}
+	}
+}
+
+// TestLogTransitionGapGrowsNoSignal checks that as the gap between
+// two minutes grows, the probability mass routed from a room into
+// NoSignal grows too, while the mass split among the other (sibling)
+// rooms does not change. This is the behavior logTransition's doc
+// describes: a long gap should look like the person went off signal,
+// not that they teleported to a different room.
+func TestLogTransitionGapGrowsNoSignal(t *testing.T) {
+	params := DefaultParams()
+	const k = 4 // 3 rooms + NoSignal
+	noSignal := k - 1
+
+	prev := math.Inf(-1)
+	var siblingMass float64
+	for gap := 1; gap <= 5; gap++ {
+		logA := logTransition(k, params, gap)
+		pNoSignal := math.Exp(logA[0][noSignal])
+		if pNoSignal <= prev {
+			t.Errorf("gap=%d: P(room->NoSignal) = %v, want > previous %v", gap, pNoSignal, prev)
+		}
+		prev = pNoSignal
+
+		sibling := math.Exp(logA[0][1]) // room 0 -> room 1, a non-stay sibling
+		if gap == 1 {
+			siblingMass = sibling
+		} else if math.Abs(sibling-siblingMass) > 1e-12 {
+			t.Errorf("gap=%d: sibling room mass = %v, want unchanged %v", gap, sibling, siblingMass)
+		}
+	}
+}
+
+func mkRec(room, numRooms int, t time.Time) rfid.SignalRec {
+	signals := make([]float32, numRooms)
+	for j := range signals {
+		signals[j] = 0.01
+	}
+	signals[room] = 10
+	return rfid.SignalRec{TimeStamp: t, Signals: signals}
+}
+
+// TestDecodeStaysInRoom checks that a sequence of strong, consistent
+// per-minute signals for a single room decodes to that room
+// throughout.
+func TestDecodeStaysInRoom(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	const numRooms = 3 // 2 rooms + NoSignal
+	var recs []rfid.SignalRec
+	for i := 0; i < 10; i++ {
+		recs = append(recs, mkRec(0, numRooms, t0.Add(time.Duration(i)*time.Minute)))
+	}
+
+	path := Decode(recs, DefaultParams())
+	for i, code := range path {
+		if code != 0 {
+			t.Errorf("minute %d: decoded room %v, want 0", i, code)
+		}
+	}
+}
+
+// TestDecodeFollowsRoomChange checks that a clear, sustained change
+// in which room has the strong signal is reflected in the decoded
+// path after the change.
+func TestDecodeFollowsRoomChange(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	const numRooms = 3 // 2 rooms + NoSignal
+	var recs []rfid.SignalRec
+	for i := 0; i < 5; i++ {
+		recs = append(recs, mkRec(0, numRooms, t0.Add(time.Duration(i)*time.Minute)))
+	}
+	for i := 5; i < 10; i++ {
+		recs = append(recs, mkRec(1, numRooms, t0.Add(time.Duration(i)*time.Minute)))
+	}
+
+	path := Decode(recs, DefaultParams())
+	for i := 0; i < 5; i++ {
+		if path[i] != 0 {
+			t.Errorf("minute %d: decoded room %v, want 0", i, path[i])
+		}
+	}
+	for i := 5; i < 10; i++ {
+		if path[i] != 1 {
+			t.Errorf("minute %d: decoded room %v, want 1", i, path[i])
+		}
+	}
+}
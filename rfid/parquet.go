@@ -0,0 +1,254 @@
+package rfid
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet"
+	"github.com/apache/arrow/go/v14/parquet/compress"
+	"github.com/apache/arrow/go/v14/parquet/file"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+)
+
+// parquetRowGroupSize is the target number of rows per Parquet row
+// group, chosen so that R/Python consumers (pyarrow, arrow-r) can
+// memory-map and column-project the file without decoding it whole.
+const parquetRowGroupSize = 100_000
+
+// parquetSchema builds the Arrow schema shared by ParquetWriter and
+// ParquetReader: TagId, the CSN/UMid identifier (plus Clarity
+// check-in/check-out for patients), TimeStamp, then one FLOAT column
+// per room.
+func parquetSchema(cfg *Config, group string) (*arrow.Schema, error) {
+
+	// timestampUTC is used for every timestamp column so that Parquet
+	// records TIMESTAMP(MILLIS, UTC) rather than a timezone-less
+	// TIMESTAMP(MILLIS); without it, isAdjustedToUTC is unset and
+	// readers such as pyarrow and arrow-r treat the column as local time.
+	timestampUTC := &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}
+
+	fields := []arrow.Field{
+		{Name: "TagId", Type: arrow.PrimitiveTypes.Uint64},
+	}
+	switch group {
+	case "patient":
+		fields = append(fields,
+			arrow.Field{Name: "CSN", Type: arrow.PrimitiveTypes.Uint64},
+			arrow.Field{Name: "ClarityStart", Type: timestampUTC, Nullable: true},
+			arrow.Field{Name: "ClarityEnd", Type: timestampUTC, Nullable: true},
+		)
+	case "provider":
+		fields = append(fields, arrow.Field{Name: "UMid", Type: arrow.PrimitiveTypes.Uint64})
+	default:
+		return nil, fmt.Errorf("rfid: unknown group %q", group)
+	}
+	fields = append(fields, arrow.Field{Name: "TimeStamp", Type: timestampUTC})
+	for k := 0; k < cfg.NumRooms(); k++ {
+		fields = append(fields, arrow.Field{Name: cfg.RoomName(RoomCode(k)), Type: arrow.PrimitiveTypes.Float32})
+	}
+
+	return arrow.NewSchema(fields, nil), nil
+}
+
+// ParquetWriter writes a stream of SignalRec values to a Parquet
+// file, with one FLOAT column per room plus TagId, the CSN/UMid
+// identifier, TimeStamp, and (for patients) ClarityStart/ClarityEnd.
+// Row groups of parquetRowGroupSize rows are flushed as they fill, and
+// timestamps are stored as TIMESTAMP(MILLIS, UTC).
+type ParquetWriter struct {
+	group string
+	fw    *pqarrow.FileWriter
+	bld   *array.RecordBuilder
+	n     int
+}
+
+// NewParquetWriter returns a ParquetWriter that writes to w.
+func NewParquetWriter(w io.Writer, cfg *Config, group string) (*ParquetWriter, error) {
+
+	schema, err := parquetSchema(cfg, group)
+	if err != nil {
+		return nil, err
+	}
+
+	props := parquet.NewWriterProperties(
+		parquet.WithCompression(compress.Codecs.Snappy),
+		parquet.WithDictionaryDefault(false),
+	)
+
+	// The pqarrow file writer closes its sink on Close if the sink is an
+	// io.Closer; hiding w's Close method leaves closing w to the caller.
+	fw, err := pqarrow.NewFileWriter(schema, struct{ io.Writer }{w}, props, pqarrow.DefaultWriterProps())
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParquetWriter{
+		group: group,
+		fw:    fw,
+		bld:   array.NewRecordBuilder(memory.NewGoAllocator(), schema),
+	}, nil
+}
+
+// Write appends rec to the current row group, flushing a row group to
+// disk once parquetRowGroupSize rows have accumulated.
+func (pw *ParquetWriter) Write(rec *SignalRec) error {
+
+	col := 0
+	pw.bld.Field(col).(*array.Uint64Builder).Append(rec.TagId)
+	col++
+
+	switch pw.group {
+	case "patient":
+		pw.bld.Field(col).(*array.Uint64Builder).Append(rec.CSN)
+		col++
+		appendTimestamp(pw.bld.Field(col).(*array.TimestampBuilder), rec.ClarityStart)
+		col++
+		appendTimestamp(pw.bld.Field(col).(*array.TimestampBuilder), rec.ClarityEnd)
+		col++
+	case "provider":
+		pw.bld.Field(col).(*array.Uint64Builder).Append(rec.UMid)
+		col++
+	}
+
+	appendTimestamp(pw.bld.Field(col).(*array.TimestampBuilder), rec.TimeStamp)
+	col++
+
+	for _, z := range rec.Signals {
+		pw.bld.Field(col).(*array.Float32Builder).Append(z)
+		col++
+	}
+
+	pw.n++
+	if pw.n >= parquetRowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+func appendTimestamp(b *array.TimestampBuilder, t time.Time) {
+	if t.IsZero() {
+		b.AppendNull()
+		return
+	}
+	b.Append(arrow.Timestamp(t.UnixMilli()))
+}
+
+func (pw *ParquetWriter) flush() error {
+	if pw.n == 0 {
+		return nil
+	}
+	rec := pw.bld.NewRecord()
+	defer rec.Release()
+	// Write, not WriteBuffered: each flushed Record becomes exactly one
+	// row group, giving the ~parquetRowGroupSize row groups that
+	// readers rely on for column projection.  WriteBuffered instead
+	// appends into arrow's shared buffered row group (default ~1Mi
+	// rows), coalescing several flushes into one oversized row group.
+	if err := pw.fw.Write(rec); err != nil {
+		return err
+	}
+	pw.n = 0
+	return nil
+}
+
+// Close flushes any buffered rows and writes the Parquet footer.
+func (pw *ParquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	return pw.fw.Close()
+}
+
+// ParquetReader reads a stream of SignalRec values previously written
+// by a ParquetWriter.
+type ParquetReader struct {
+	group string
+	rr    pqarrow.RecordReader
+	rec   arrow.Record
+	row   int
+}
+
+// NewParquetReader returns a ParquetReader that reads from r, which
+// must be the same group ("patient" or "provider") that was passed to
+// the corresponding NewParquetWriter call.
+func NewParquetReader(r parquet.ReaderAtSeeker, group string) (*ParquetReader, error) {
+
+	pf, err := file.NewParquetReader(r)
+	if err != nil {
+		return nil, err
+	}
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.NewGoAllocator())
+	if err != nil {
+		return nil, err
+	}
+	rr, err := fr.GetRecordReader(context.Background(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParquetReader{group: group, rr: rr}, nil
+}
+
+// Next returns the next SignalRec in the stream, or io.EOF once every
+// row group has been read.
+func (pr *ParquetReader) Next() (*SignalRec, error) {
+
+	if pr.rec == nil || pr.row >= int(pr.rec.NumRows()) {
+		if !pr.rr.Next() {
+			return nil, io.EOF
+		}
+		pr.rec = pr.rr.Record()
+		pr.row = 0
+	}
+
+	rec := pr.rowToSignalRec(pr.row)
+	pr.row++
+	return rec, nil
+}
+
+func (pr *ParquetReader) rowToSignalRec(row int) *SignalRec {
+
+	rec := pr.rec
+	col := 0
+	out := &SignalRec{}
+
+	out.TagId = rec.Column(col).(*array.Uint64).Value(row)
+	col++
+
+	switch pr.group {
+	case "patient":
+		out.CSN = rec.Column(col).(*array.Uint64).Value(row)
+		col++
+		out.ClarityStart = timestampValue(rec.Column(col), row)
+		col++
+		out.ClarityEnd = timestampValue(rec.Column(col), row)
+		col++
+	case "provider":
+		out.UMid = rec.Column(col).(*array.Uint64).Value(row)
+		col++
+	}
+
+	out.TimeStamp = timestampValue(rec.Column(col), row)
+	col++
+
+	n := int(rec.NumCols()) - col
+	out.Signals = make([]float32, n)
+	for j := 0; j < n; j++ {
+		out.Signals[j] = rec.Column(col + j).(*array.Float32).Value(row)
+	}
+
+	return out
+}
+
+func timestampValue(col arrow.Array, row int) time.Time {
+	tc := col.(*array.Timestamp)
+	if tc.IsNull(row) {
+		return time.Time{}
+	}
+	return tc.Value(row).ToTime(arrow.Millisecond)
+}
@@ -16,148 +16,12 @@ const (
 	Patient
 )
 
-// Type for integer codes for the rooms.
+// Type for integer codes for the rooms.  Room codes are assigned at
+// runtime by LoadConfig, based on the order that rooms appear in the
+// config file, so that a deployment's set of rooms is not fixed at
+// compile time.  See Config.RoomCode and Config.NoSignal.
 type RoomCode uint8
 
-// Integer odes for the rooms.  These need to start at zero because they are
-// used as array indices.
-const (
-	Exam1 RoomCode = iota
-	Exam2
-	Exam3
-	Exam4
-	Exam5
-	Exam6
-	Exam7
-	Exam8
-	Exam9
-	Exam10
-	Exam11
-	Exam12
-	Field1
-	Field2
-	Field3
-	Field4
-	Field5
-	IOLMaster
-	Lensometer
-	Admin
-	Checkout
-	IPW9
-	IPW2
-	Treatment
-	NoSignal
-)
-
-var (
-	// IPcode maps IP addresses to integer room codes.
-	IPcode = map[string]RoomCode{
-		"10.23.69.140": Exam1,
-		"10.23.69.141": Exam2,
-		"10.23.69.142": Exam3,
-		"10.23.69.143": Exam4,
-		"10.23.69.144": Exam5,
-		"10.23.69.145": Exam6,
-		"10.23.69.146": Exam7,
-		"10.23.69.147": Exam8,
-		"10.23.69.148": Exam9,
-		"10.23.69.149": Exam10,
-		"10.23.69.150": Exam11,
-		"10.23.69.151": Exam12,
-		"10.23.69.152": Field1,
-		"10.23.69.153": Field2,
-		"10.23.69.154": Field3,
-		"10.23.69.155": Field4,
-		"10.23.69.156": Field5,
-		"10.23.69.157": IOLMaster,
-		"10.23.69.158": Lensometer,
-		"10.23.69.159": Admin,
-		"10.23.69.160": Checkout,
-		"10.23.69.161": IPW9,
-		"10.23.69.162": IPW2,
-		"10.23.69:163": Treatment,
-		"NoSignal":     NoSignal,
-	}
-
-	// IPmap maps IP address to room names.
-	IPmap = map[string]string{
-		"10.23.69.140": "Exam1",
-		"10.23.69.141": "Exam2",
-		"10.23.69.142": "Exam3",
-		"10.23.69.143": "Exam4",
-		"10.23.69.144": "Exam5",
-		"10.23.69.145": "Exam6",
-		"10.23.69.146": "Exam7",
-		"10.23.69.147": "Exam8",
-		"10.23.69.148": "Exam9",
-		"10.23.69.149": "Exam10",
-		"10.23.69.150": "Exam11",
-		"10.23.69.151": "Exam12",
-		"10.23.69.152": "Field1",
-		"10.23.69.153": "Field2",
-		"10.23.69.154": "Field3",
-		"10.23.69.155": "Field4",
-		"10.23.69.156": "Field5",
-		"10.23.69.157": "IOLMaster",
-		"10.23.69.158": "Lensometer",
-		"10.23.69.159": "Admin",
-		"10.23.69.160": "Checkout",
-		"10.23.69.161": "IPW9",
-		"10.23.69.162": "IPW2",
-		"10.23.69:163": "Treatment",
-		"NoSignal":     "NoSignal",
-	}
-
-	// RoomName maps room codes to room names.
-	RoomName = map[RoomCode]string{
-		Exam1:      "Exam1",
-		Exam2:      "Exam2",
-		Exam3:      "Exam3",
-		Exam4:      "Exam4",
-		Exam5:      "Exam5",
-		Exam6:      "Exam6",
-		Exam7:      "Exam7",
-		Exam8:      "Exam8",
-		Exam9:      "Exam9",
-		Exam10:     "Exam10",
-		Exam11:     "Exam11",
-		Exam12:     "Exam12",
-		Field1:     "Field1",
-		Field2:     "Field2",
-		Field3:     "Field3",
-		Field4:     "Field4",
-		Field5:     "Field5",
-		IOLMaster:  "IOLMaster",
-		Lensometer: "Lensometer",
-		Admin:      "Admin",
-		Checkout:   "Checkout",
-		IPW9:       "IPW9",
-		IPW2:       "IPW2",
-		Treatment:  "Treatment",
-		NoSignal:   "NoSignal",
-	}
-
-	// PTmap maps person category codes to text labels.
-	PTmap = map[PersonType]string{
-		Provider: "Provider",
-		Patient:  "Patient",
-	}
-
-	// Provmap maps provider category codes to text labels.
-	ProvMap = map[ProviderType]string{
-		Attending:     "Attending",
-		Fellow:        "Fellow",
-		Resident:      "Resident",
-		Technician:    "Technician",
-		Assistant:     "Assistant",
-		Educator:      "Educator",
-		Administrator: "Administrator",
-		Clerk:         "Clerk",
-		Imaging:       "Imaging",
-		Other:         "Other",
-	}
-)
-
 // Provider type is an integer code for a category of provider.
 type ProviderType int
 
@@ -222,6 +86,12 @@ type SignalRec struct {
 	CSN       uint64
 	TimeStamp time.Time
 	Signals   []float32
+
+	// ClarityStart and ClarityEnd are the Clarity check-in and
+	// check-out times for this person's appointment, if a matching
+	// Clarity record was found; the zero time.Time otherwise.
+	ClarityStart time.Time
+	ClarityEnd   time.Time
 }
 
 // parsePatient parses a patient record from its raw input format into a struct.
@@ -356,8 +226,9 @@ func (rec *RFIDrecord) parseProvider(tag string, rfi *RFIDinfo) bool {
 }
 
 // Parse takes a row of raw data, split into text tokens, and uses it
-// to populate an RFID tag struct.
-func (rec *RFIDrecord) Parse(f []string, rfi *RFIDinfo) bool {
+// to populate an RFID tag struct.  cfg supplies the IP-to-room mapping
+// for the clinic that the data was collected at.
+func (rec *RFIDrecord) Parse(f []string, rfi *RFIDinfo, cfg *Config) bool {
 
 	var err error
 
@@ -368,7 +239,7 @@ func (rec *RFIDrecord) Parse(f []string, rfi *RFIDinfo) bool {
 	}
 
 	// Get the IP address as a numeric code
-	c, ok := IPcode[f[1]]
+	c, ok := cfg.RoomCode(f[1])
 	if !ok {
 		// Not a known IP address
 		rfi.InvalidIP++
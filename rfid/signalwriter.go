@@ -0,0 +1,10 @@
+package rfid
+
+// SignalWriter is implemented by each supported output format for a
+// stream of SignalRec values: FrameWriter (gob), CSVWriter, and
+// ParquetWriter.  Close flushes any buffered state but does not close
+// the underlying io.Writer, which remains the caller's responsibility.
+type SignalWriter interface {
+	Write(rec *SignalRec) error
+	Close() error
+}
@@ -3,36 +3,105 @@ package main
 import (
 	"compress/gzip"
 	"encoding/csv"
-	"encoding/gob"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 
 	"github.com/kshedden/rfid2/rfid"
+	"github.com/kshedden/rfid2/rfid/locate"
 )
 
+// groupID returns the id used to group consecutive SignalRec values
+// belonging to the same person: CSN for patients, UMid for providers.
+func groupID(gn string, r *rfid.SignalRec) uint64 {
+	if gn == "patient" {
+		return r.CSN
+	}
+	return r.UMid
+}
+
+// signalReader is satisfied by both rfid.FrameReader and
+// rfid.ParquetReader, so the export loop below does not need to care
+// which intermediate format it is reading.
+type signalReader interface {
+	Next() (*rfid.SignalRec, error)
+}
+
+// multiCloser closes a sequence of io.Closers in order, wrapping them
+// as a single io.Closer.
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var firstErr error
+	for _, c := range m {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// openSignalReader opens the intermediate per-day signal file for
+// group ("patient" or "provider") written in the given format ("gob"
+// or "parquet") and returns a signalReader for it along with the
+// io.Closer needed to close the underlying file.
+func openSignalReader(format, group string) (signalReader, io.Closer, error) {
+
+	switch format {
+	case "gob":
+		f, err := os.Open(group + "_signals.gob.gz")
+		if err != nil {
+			return nil, nil, err
+		}
+		g, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		return rfid.NewFrameReader(g), multiCloser{g, f}, nil
+
+	case "parquet":
+		f, err := os.Open(group + "_signals.parquet")
+		if err != nil {
+			return nil, nil, err
+		}
+		r, err := rfid.NewParquetReader(f, group)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r, f, nil
+
+	case "csv":
+		return nil, nil, fmt.Errorf("-format csv was already written directly as CSV by the processor; there is nothing to export")
+
+	default:
+		return nil, nil, fmt.Errorf("unknown -format %q, expected gob or parquet", format)
+	}
+}
+
 func main() {
 
-	if len(os.Args) != 2 || (os.Args[1] != "patient" && os.Args[1] != "provider") {
-		msg := fmt.Sprintf("Usage: %s [patient|provider]\n", os.Args[0])
+	format := flag.String("format", "gob", "intermediate signal file format to read: gob or parquet")
+	decode := flag.Bool("decode", false, "append a decoded room column produced by the locate HMM")
+	flag.Parse()
+
+	if flag.NArg() != 1 || (flag.Arg(0) != "patient" && flag.Arg(0) != "provider") {
+		msg := fmt.Sprintf("Usage: %s [-format gob|parquet] [-decode] [patient|provider]\n", os.Args[0])
 		os.Stderr.WriteString(msg)
 		os.Exit(1)
 	}
-	gn := os.Args[1]
+	gn := flag.Arg(0)
 
-	fid, err := os.Open(fmt.Sprintf("%s_signals.gob.gz", gn))
+	cfg, err := rfid.LoadConfig("clinic.json")
 	if err != nil {
 		panic(err)
 	}
-	defer fid.Close()
 
-	gid, err := gzip.NewReader(fid)
+	fr, closer, err := openSignalReader(*format, gn)
 	if err != nil {
 		panic(err)
 	}
-	defer gid.Close()
-
-	dec := gob.NewDecoder(gid)
+	defer closer.Close()
 
 	outf, err := os.Create(fmt.Sprintf("%s_signals.csv.gz", gn))
 	if err != nil {
@@ -43,60 +112,95 @@ func main() {
 	out := gzip.NewWriter(outf)
 	defer out.Close()
 	enc := csv.NewWriter(out)
+	defer enc.Flush()
 
 	// Write out the header
-	var tr []string
+	var hdr []string
 	switch gn {
 	case "patient":
-		tr = []string{"TagId", "CSN", "ClarityStart", "ClarityEnd", "Time"}
+		hdr = []string{"TagId", "CSN", "ClarityStart", "ClarityEnd", "Time"}
 	case "provider":
-		tr = []string{"TagId", "UMid", "Time"}
-	default:
-		panic(fmt.Sprintf("Unknown group type: %s\n", gn))
+		hdr = []string{"TagId", "UMid", "Time"}
 	}
-	for k := 0; k < len(rfid.IPcode); k++ {
-		tr = append(tr, rfid.RoomName[rfid.RoomCode(k)])
+	for k := 0; k < cfg.NumRooms(); k++ {
+		hdr = append(hdr, cfg.RoomName(rfid.RoomCode(k)))
 	}
-	if err := enc.Write(tr); err != nil {
+	if *decode {
+		hdr = append(hdr, "DecodedRoom")
+	}
+	if err := enc.Write(hdr); err != nil {
 		panic(err)
 	}
 
-	var r rfid.SignalRec
+	// writeBatch writes every record in a single person's batch,
+	// optionally appending a column with the room decoded by the
+	// locate HMM.
+	writeBatch := func(batch []rfid.SignalRec) {
+
+		var rooms []rfid.RoomCode
+		if *decode {
+			rooms = locate.Decode(batch, locate.DefaultParams())
+		}
+
+		var tr []string
+		for j, r := range batch {
+			tr = tr[0:0]
+			tr = append(tr, fmt.Sprintf("%d", r.TagId))
+			switch gn {
+			case "provider":
+				tr = append(tr, fmt.Sprintf("%d", r.UMid))
+			case "patient":
+				tr = append(tr, fmt.Sprintf("%d", r.CSN))
+				if !r.ClarityStart.IsZero() {
+					tr = append(tr, fmt.Sprintf("%s", r.ClarityStart.Format("2006-01-02T15:04")))
+				} else {
+					tr = append(tr, "")
+				}
+				if !r.ClarityEnd.IsZero() {
+					tr = append(tr, fmt.Sprintf("%s", r.ClarityEnd.Format("2006-01-02T15:04")))
+				} else {
+					tr = append(tr, "")
+				}
+			}
+			tr = append(tr, fmt.Sprintf("%s", r.TimeStamp.Format("2006-01-02T15:04")))
+			for _, z := range r.Signals {
+				tr = append(tr, fmt.Sprintf("%.0f", 1000000*z))
+			}
+			if *decode {
+				tr = append(tr, cfg.RoomName(rooms[j]))
+			}
+
+			if err := enc.Write(tr); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	var batch []rfid.SignalRec
 	for {
-		err := dec.Decode(&r)
+		r, err := fr.Next()
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			panic(err)
 		}
 
-		tr = tr[0:0]
-		tr = append(tr, fmt.Sprintf("%d", r.TagId))
-		switch gn {
-		case "provider":
-			tr = append(tr, fmt.Sprintf("%d", r.UMid))
-		case "patient":
-			tr = append(tr, fmt.Sprintf("%d", r.CSN))
-			if !r.ClarityStart.IsZero() {
-				tr = append(tr, fmt.Sprintf("%s", r.ClarityStart.Format("2006-01-02T15:04")))
-			} else {
-				tr = append(tr, "")
-			}
-			if !r.ClarityEnd.IsZero() {
-				tr = append(tr, fmt.Sprintf("%s", r.ClarityEnd.Format("2006-01-02T15:04")))
-			} else {
-				tr = append(tr, "")
-			}
-		default:
-			panic(fmt.Sprintf("Unknown group type: %s\n", gn))
-		}
-		tr = append(tr, fmt.Sprintf("%s", r.TimeStamp.Format("2006-01-02T15:04")))
-		for _, z := range r.Signals {
-			tr = append(tr, fmt.Sprintf("%.0f", 1000000*z))
+		if len(batch) > 0 && groupID(gn, &batch[0]) != groupID(gn, r) {
+			writeBatch(batch)
+			batch = batch[0:0]
 		}
+		batch = append(batch, *r)
+	}
+	if len(batch) > 0 {
+		writeBatch(batch)
+	}
 
-		if err := enc.Write(tr); err != nil {
-			panic(err)
+	if fr, ok := fr.(*rfid.FrameReader); ok {
+		if fr.Stats.CorruptFrames > 0 {
+			fmt.Printf("Skipped %d corrupted frame(s) out of %d\n", fr.Stats.CorruptFrames, fr.Stats.Frames)
+		}
+		if fr.Stats.Truncated {
+			fmt.Printf("Input ended with a truncated frame, %d frame(s) decoded\n", fr.Stats.Frames)
 		}
 	}
 }